@@ -0,0 +1,137 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+const maxBatchSize = 100
+
+// BatchRequest is one sub-request packed into a /v2/batch call.
+type BatchRequest struct {
+	Id     string                 `json:"id"`
+	Method string                 `json:"method"`
+	Url    string                 `json:"url"`
+	Body   map[string]interface{} `json:"body"`
+}
+
+// BatchResult is the per-item outcome Aliyun returns for the BatchRequest
+// with the same Id.
+type BatchResult struct {
+	Id     string `json:"id"`
+	Status int    `json:"status"`
+	Body   struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"body"`
+}
+
+// Batch packs up to maxBatchSize sub-requests into each POST to v2/batch
+// and splits the multi-status response back out by Id, chunking larger
+// request sets into ceil(len(requests)/maxBatchSize) round-trips.
+func (drive *Drive) Batch(ctx context.Context, requests []BatchRequest) (map[string]BatchResult, error) {
+	results := make(map[string]BatchResult, len(requests))
+
+	for start := 0; start < len(requests); start += maxBatchSize {
+		end := start + maxBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		body := map[string]interface{}{
+			"requests": requests[start:end],
+			"resource": "file",
+		}
+
+		var resp struct {
+			Responses []BatchResult `json:"responses"`
+		}
+		err := drive.jsonRequest(ctx, "POST", apiBatch, &body, &resp)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to post batch request")
+		}
+
+		for _, r := range resp.Responses {
+			results[r.Id] = r
+		}
+	}
+
+	return results, nil
+}
+
+func batchId(prefix string, i int) string {
+	return fmt.Sprintf("%s-%d", prefix, i)
+}
+
+func checkBatchResult(result BatchResult) error {
+	if result.Status >= 200 && result.Status < 300 {
+		return nil
+	}
+	return errors.Errorf("batch item %s failed with status %d: %s", result.Id, result.Status, result.Body.Message)
+}
+
+func (drive *Drive) batchOp(ctx context.Context, prefix, url string, bodies []map[string]interface{}) error {
+	requests := make([]BatchRequest, len(bodies))
+	for i, body := range bodies {
+		requests[i] = BatchRequest{
+			Id:     batchId(prefix, i),
+			Method: "POST",
+			Url:    url,
+			Body:   body,
+		}
+	}
+
+	results, err := drive.Batch(ctx, requests)
+	if err != nil {
+		return err
+	}
+	for i := range bodies {
+		if err := checkBatchResult(results[batchId(prefix, i)]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MoveBatch moves many nodes to dstParent in as few round-trips as
+// possible.
+func (drive *Drive) MoveBatch(ctx context.Context, nodes []*Node, dstParent *Node) error {
+	bodies := make([]map[string]interface{}, len(nodes))
+	for i, node := range nodes {
+		bodies[i] = map[string]interface{}{
+			"drive_id":          drive.driveId,
+			"file_id":           node.NodeId,
+			"to_parent_file_id": dstParent.NodeId,
+		}
+	}
+	return drive.batchOp(ctx, "move", "/file/move", bodies)
+}
+
+// CopyBatch copies many nodes into dstParent in as few round-trips as
+// possible.
+func (drive *Drive) CopyBatch(ctx context.Context, nodes []*Node, dstParent *Node) error {
+	bodies := make([]map[string]interface{}, len(nodes))
+	for i, node := range nodes {
+		bodies[i] = map[string]interface{}{
+			"drive_id":          drive.driveId,
+			"file_id":           node.NodeId,
+			"to_parent_file_id": dstParent.NodeId,
+		}
+	}
+	return drive.batchOp(ctx, "copy", "/file/copy", bodies)
+}
+
+// RemoveBatch moves many nodes to the trash in as few round-trips as
+// possible.
+func (drive *Drive) RemoveBatch(ctx context.Context, nodes []*Node) error {
+	bodies := make([]map[string]interface{}, len(nodes))
+	for i, node := range nodes {
+		bodies[i] = map[string]interface{}{
+			"drive_id": drive.driveId,
+			"file_id":  node.NodeId,
+		}
+	}
+	return drive.batchOp(ctx, "trash", "/recyclebin/trash", bodies)
+}
@@ -0,0 +1,120 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// roundTripFunc lets a plain function satisfy http.RoundTripper, for
+// stubbing out the HTTP layer in tests.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// newTestDrive returns a Drive with a pre-seeded, never-expiring access
+// token and an httpClient backed by rt, so jsonRequest never tries to hit
+// the real refresh-token endpoint.
+func newTestDrive(rt roundTripFunc) *Drive {
+	drive := &Drive{
+		httpClient: &http.Client{Transport: rt},
+	}
+	drive.setToken("test-token", time.Now().Unix()+3600)
+	return drive
+}
+
+func TestBatchChunksRequestsIntoGroupsOfMaxBatchSize(t *testing.T) {
+	var gotSizes []int
+
+	drive := newTestDrive(func(req *http.Request) (*http.Response, error) {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			t.Fatalf("failed to read request body: %v", err)
+		}
+
+		var body struct {
+			Requests []BatchRequest `json:"requests"`
+		}
+		if err := json.Unmarshal(b, &body); err != nil {
+			t.Fatalf("failed to unmarshal request body: %v", err)
+		}
+		gotSizes = append(gotSizes, len(body.Requests))
+
+		resp := struct {
+			Responses []BatchResult `json:"responses"`
+		}{}
+		for _, r := range body.Requests {
+			resp.Responses = append(resp.Responses, BatchResult{Id: r.Id, Status: http.StatusOK})
+		}
+		respBody, _ := json.Marshal(resp)
+
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewReader(respBody)),
+		}, nil
+	})
+
+	const total = 250
+	requests := make([]BatchRequest, total)
+	for i := range requests {
+		requests[i] = BatchRequest{Id: fmt.Sprintf("item-%d", i), Method: "POST", Url: "/file/move"}
+	}
+
+	results, err := drive.Batch(context.Background(), requests)
+	if err != nil {
+		t.Fatalf("Batch returned error: %v", err)
+	}
+
+	wantSizes := []int{maxBatchSize, maxBatchSize, total - 2*maxBatchSize}
+	if len(gotSizes) != len(wantSizes) {
+		t.Fatalf("Batch made %d round-trips %v, want %d round-trips %v", len(gotSizes), gotSizes, len(wantSizes), wantSizes)
+	}
+	for i, want := range wantSizes {
+		if gotSizes[i] != want {
+			t.Errorf("round-trip %d had %d requests, want %d", i, gotSizes[i], want)
+		}
+	}
+
+	if len(results) != total {
+		t.Fatalf("Batch returned %d results, want %d", len(results), total)
+	}
+	for _, req := range requests {
+		if results[req.Id].Status != http.StatusOK {
+			t.Errorf("result for %s has status %d, want 200", req.Id, results[req.Id].Status)
+		}
+	}
+}
+
+func TestBatchSingleRoundTripWhenUnderLimit(t *testing.T) {
+	var callCount int
+
+	drive := newTestDrive(func(req *http.Request) (*http.Response, error) {
+		callCount++
+		resp := struct {
+			Responses []BatchResult `json:"responses"`
+		}{Responses: []BatchResult{{Id: "only", Status: http.StatusOK}}}
+		respBody, _ := json.Marshal(resp)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       ioutil.NopCloser(bytes.NewReader(respBody)),
+		}, nil
+	})
+
+	results, err := drive.Batch(context.Background(), []BatchRequest{{Id: "only", Method: "POST", Url: "/file/move"}})
+	if err != nil {
+		t.Fatalf("Batch returned error: %v", err)
+	}
+	if callCount != 1 {
+		t.Errorf("Batch made %d round-trips, want 1", callCount)
+	}
+	if results["only"].Status != http.StatusOK {
+		t.Errorf("result status = %d, want 200", results["only"].Status)
+	}
+}
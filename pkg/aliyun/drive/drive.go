@@ -43,7 +43,9 @@ const (
 )
 
 const (
-	MaxPartSize = 1024 * 1024 * 1024 // 1G
+	MaxPartSize              = 1024 * 1024 * 1024 // 1G, Aliyun's hard limit per part
+	DefaultPartSize          = 16 * 1024 * 1024    // 16M, small enough for real parallelism and retry granularity
+	DefaultUploadConcurrency = 4
 )
 
 var (
@@ -57,17 +59,44 @@ type Fs interface {
 	Rename(ctx context.Context, node *Node, newName string) error
 	Move(ctx context.Context, node *Node, dstParent *Node, dstName string) error
 	Remove(ctx context.Context, node *Node) error
-	Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadCloser, error)
+	Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadSeekCloser, error)
+	OpenRange(ctx context.Context, node *Node, offset, length int64, headers map[string]string) (io.ReadCloser, error)
 	CreateFile(ctx context.Context, fullPath string, size int64, in io.Reader, overwrite bool) (*Node, error)
 	CalcProof(fileSize int64, in *os.File) (*os.File, string, error)
 	CreateFileWithProof(ctx context.Context, fullPath string, size int64, in io.Reader, sha1Code string, proofCode string, overwrite bool) (*Node, error)
 	Copy(ctx context.Context, node *Node, dstParent *Node, dstName string) error
+	Batch(ctx context.Context, requests []BatchRequest) (map[string]BatchResult, error)
+	MoveBatch(ctx context.Context, nodes []*Node, dstParent *Node) error
+	CopyBatch(ctx context.Context, nodes []*Node, dstParent *Node) error
+	RemoveBatch(ctx context.Context, nodes []*Node) error
 }
 
 type Config struct {
 	RefreshToken string
 	IsAlbum      bool
 	HttpClient   *http.Client
+
+	// PartSize is the size in bytes of each multipart upload chunk.
+	// Defaults to DefaultPartSize when left at zero.
+	PartSize int64
+	// UploadConcurrency bounds how many parts are uploaded in parallel.
+	// Defaults to DefaultUploadConcurrency when left at zero.
+	UploadConcurrency int
+	// ProgressFunc, when set, is called after each part finishes
+	// uploading with the cumulative bytes uploaded and the total size.
+	ProgressFunc func(uploaded, total int64)
+
+	// UploadStateStore, when set, persists multipart upload progress so
+	// CreateFile can resume an interrupted upload instead of starting
+	// over from byte zero.
+	UploadStateStore UploadStateStore
+
+	// DownloadConcurrency bounds how many ranged GETs Open issues in
+	// parallel. Defaults to DefaultDownloadConcurrency when left at zero.
+	DownloadConcurrency int
+	// DownloadChunkSize is the size in bytes of each ranged GET Open
+	// issues. Defaults to DefaultDownloadChunkSize when left at zero.
+	DownloadChunkSize int64
 }
 
 func (config Config) String() string {
@@ -89,6 +118,22 @@ type token struct {
 	expireAt    int64
 }
 
+// getToken returns the current access token, guarded by drive.mutex since
+// it's read from request-building goroutines (e.g. parallel ranged
+// downloads) concurrently with refreshToken writing it.
+func (drive *Drive) getToken() (string, int64) {
+	drive.mutex.Lock()
+	defer drive.mutex.Unlock()
+	return drive.accessToken, drive.expireAt
+}
+
+func (drive *Drive) setToken(accessToken string, expireAt int64) {
+	drive.mutex.Lock()
+	defer drive.mutex.Unlock()
+	drive.accessToken = accessToken
+	drive.expireAt = expireAt
+}
+
 func (drive *Drive) String() string {
 	return fmt.Sprintf("AliyunDrive{driveId: %s}", drive.driveId)
 }
@@ -122,14 +167,14 @@ func (drive *Drive) refreshToken(ctx context.Context) error {
 		"grant_type":    "refresh_token",
 	}
 
-	var body io.Reader
 	b, err := json.Marshal(&data)
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
-	body = bytes.NewBuffer(b)
-	res, err := drive.request(ctx, "POST", apiRefreshToken, headers, body)
+	res, err := pace(ctx, func() (*http.Response, error) {
+		return drive.request(ctx, "POST", apiRefreshToken, headers, bytes.NewBuffer(b))
+	})
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -145,23 +190,18 @@ func (drive *Drive) refreshToken(ctx context.Context) error {
 		return errors.Wrapf(err, `failed to parse response "%s"`, string(b))
 	}
 
-	drive.accessToken = token.AccessToken
-	drive.expireAt = token.ExpiresIn + time.Now().Unix()
+	drive.setToken(token.AccessToken, token.ExpiresIn+time.Now().Unix())
 	return nil
 }
 
 func (drive *Drive) jsonRequest(ctx context.Context, method, url string, request interface{}, response interface{}) error {
 	// Token expired, refresh access
-	if drive.expireAt < time.Now().Unix() {
+	if _, expireAt := drive.getToken(); expireAt < time.Now().Unix() {
 		err := drive.refreshToken(ctx)
 		if err != nil {
 			return errors.WithStack(err)
 		}
 	}
-	headers := map[string]string{
-		"content-type":  "application/json;charset=UTF-8",
-		"authorization": "Bearer " + drive.accessToken,
-	}
 
 	var bodyBytes []byte
 	if request != nil {
@@ -172,32 +212,57 @@ func (drive *Drive) jsonRequest(ctx context.Context, method, url string, request
 		bodyBytes = b
 	}
 
-	res, err := drive.request(ctx, method, url, headers, bytes.NewBuffer(bodyBytes))
-	if err != nil {
-		return errors.WithStack(err)
-	}
-	defer res.Body.Close()
-
-	if res.StatusCode == http.StatusNotFound {
-		return errors.Wrapf(os.ErrNotExist, `failed to request "%s", got "%d"`, url, res.StatusCode)
-	}
-
-	if res.StatusCode >= 400 {
-		return errors.Errorf(`failed to request "%s", got "%d"`, url, res.StatusCode)
-	}
+	// one retry budget for a token that went bad before our wall-clock
+	// expiry fired, e.g. after a password change
+	for attempt := 0; ; attempt++ {
+		accessToken, _ := drive.getToken()
+		headers := map[string]string{
+			"content-type":  "application/json;charset=UTF-8",
+			"authorization": "Bearer " + accessToken,
+		}
 
-	if response != nil {
-		b, err := ioutil.ReadAll(res.Body)
+		res, err := pace(ctx, func() (*http.Response, error) {
+			return drive.request(ctx, method, url, headers, bytes.NewBuffer(bodyBytes))
+		})
 		if err != nil {
 			return errors.WithStack(err)
 		}
-		err = json.Unmarshal(b, &response)
-		if err != nil {
-			return errors.Wrapf(err, `failed to parse response "%s"`, string(b))
+
+		if res.StatusCode == http.StatusNotFound {
+			res.Body.Close()
+			return errors.Wrapf(os.ErrNotExist, `failed to request "%s", got "%d"`, url, res.StatusCode)
 		}
-	}
 
-	return nil
+		if res.StatusCode >= 400 {
+			b, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+
+			apiErr := &APIError{}
+			if json.Unmarshal(b, apiErr) == nil && apiErr.Code != "" {
+				if apiErr.Code == "AccessTokenInvalid" && attempt == 0 {
+					if refreshErr := drive.refreshToken(ctx); refreshErr == nil {
+						continue
+					}
+				}
+				return errors.WithStack(apiErr)
+			}
+			return errors.Errorf(`failed to request "%s", got "%d"`, url, res.StatusCode)
+		}
+
+		defer res.Body.Close()
+		if response != nil {
+			b, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			err = json.Unmarshal(b, &response)
+			if err != nil {
+				return errors.Wrapf(err, `failed to parse response "%s"`, string(b))
+			}
+		}
+
+		return nil
+	}
 }
 
 func NewFs(ctx context.Context, config *Config) (Fs, error) {
@@ -450,6 +515,14 @@ func (drive *Drive) Move(ctx context.Context, node *Node, dstParent *Node, dstNa
 	return nil
 }
 
+// Remove moves node to the trash. Aliyun's trash is recursive for
+// folders (confirmed against the API's own behavior: trashing a folder
+// trashes its contents server-side), so unlike Move/Copy there's no
+// per-descendant work for a batch plan to save here — a single apiTrash
+// call handles a folder of any size in one round-trip. Large-folder
+// removal is therefore not routed through RemoveBatch;
+// RemoveBatch stays available directly for callers that already have an
+// explicit, unrelated list of nodes to remove in bulk.
 func (drive *Drive) Remove(ctx context.Context, node *Node) error {
 	if err := drive.checkRoot(node); err != nil {
 		return err
@@ -480,7 +553,16 @@ func (drive *Drive) getDownloadUrl(ctx context.Context, node *Node) (*DownloadUr
 	return &detail, nil
 }
 
-func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadCloser, error) {
+// readSeekCloser adapts a fully-buffered *bytes.Reader to io.ReadSeekCloser
+// for content (like the .livp zip below) that's already entirely in
+// memory and so needs no real Close behavior.
+type readSeekCloser struct {
+	*bytes.Reader
+}
+
+func (readSeekCloser) Close() error { return nil }
+
+func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadSeekCloser, error) {
 	if err := drive.checkRoot(node); err != nil {
 		return nil, err
 	}
@@ -490,14 +572,10 @@ func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]str
 		return nil, err
 	}
 
-	url := downloadUrl.Url
-	if url != "" {
-		res, err := drive.request(ctx, "GET", url, headers, nil)
-		if err != nil {
-			return nil, errors.Wrapf(err, `failed to download "%s"`, url)
-		}
-
-		return res.Body, nil
+	if downloadUrl.Url != "" {
+		r := newRangedReader(ctx, drive.downloadUrlOpener, drive.rangedGet, node, node.Size, drive.config.DownloadConcurrency, drive.config.DownloadChunkSize, headers)
+		r.url = downloadUrl.Url // already resolved above, seed the cache
+		return r, nil
 	}
 
 	// for iOS live photos (.livp)
@@ -529,7 +607,7 @@ func (drive *Drive) Open(ctx context.Context, node *Node, headers map[string]str
 			return nil, errors.WithStack(err)
 		}
 
-		return io.NopCloser(&buf), nil
+		return readSeekCloser{bytes.NewReader(buf.Bytes())}, nil
 	}
 
 	return nil, errors.Errorf(`failed to open "%s"`, node)
@@ -562,28 +640,56 @@ func calcProof(accessToken string, fileSize int64, in *os.File) (*os.File, strin
 }
 
 func (drive *Drive) CalcProof(fileSize int64, in *os.File) (*os.File, string, error) {
-	return calcProof(drive.accessToken, fileSize, in)
+	accessToken, _ := drive.getToken()
+	return calcProof(accessToken, fileSize, in)
 }
 
+// CreateFile no longer hashes the whole file up front: CreateFileWithProof
+// now probes with a cheap pre-hash first and only pays for a full SHA1 when
+// the server reports a possible rapid-upload match, so it's safe to pass
+// arbitrary (possibly huge) readers here.
 func (drive *Drive) CreateFile(ctx context.Context, fullPath string, size int64, in io.Reader, overwrite bool) (*Node, error) {
-	sha1Code := ""
-	proofCode := ""
+	return drive.CreateFileWithProof(ctx, fullPath, size, in, "", "", overwrite)
+}
+
+const preHashSize = 1024
 
-	fin, ok := in.(*os.File)
-	if ok {
-		in, sha1Code, _ = CalcSha1(fin)
-		in, proofCode, _ = drive.CalcProof(size, fin)
+// peekPrefix reads up to n bytes from in and returns them along with a
+// reader that reproduces those bytes before continuing on to the rest of
+// in. It lets callers inspect the start of an arbitrary io.Reader without
+// needing it to be seekable.
+func peekPrefix(in io.Reader, n int64) ([]byte, io.Reader, error) {
+	prefix := make([]byte, n)
+	read, err := io.ReadFull(in, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, nil, errors.WithStack(err)
 	}
+	prefix = prefix[:read]
+	return prefix, io.MultiReader(bytes.NewReader(prefix), in), nil
+}
+
+func calcPreHash(prefix []byte) string {
+	h := sha1.New()
+	h.Write(prefix)
+	return fmt.Sprintf("%X", h.Sum(nil))
+}
 
-	return drive.CreateFileWithProof(ctx, fullPath, size, in, sha1Code, proofCode, overwrite)
+// calcProofFromPrefix mirrors calcProof for content that's already been
+// buffered in memory instead of living in a seekable *os.File.
+func calcProofFromPrefix(accessToken string, fileSize int64, content []byte) string {
+	start := CalcProofOffset(accessToken, fileSize)
+	if start < 0 || start+8 > int64(len(content)) {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(content[start : start+8])
 }
 
-func makePartInfoList(size int64) []*PartInfo {
+func makePartInfoList(size, partSize int64) []*PartInfo {
 	partInfoNum := 0
-	if size%MaxPartSize > 0 {
+	if size%partSize > 0 {
 		partInfoNum++
 	}
-	partInfoNum += int(size / MaxPartSize)
+	partInfoNum += int(size / partSize)
 	list := make([]*PartInfo, partInfoNum)
 	for i := 0; i < partInfoNum; i++ {
 		list[i] = &PartInfo{
@@ -593,6 +699,19 @@ func makePartInfoList(size int64) []*PartInfo {
 	return list
 }
 
+// partSizeOrDefault clamps Config.PartSize to a sane range, falling back
+// to DefaultPartSize when unset.
+func (drive *Drive) partSizeOrDefault() int64 {
+	partSize := drive.config.PartSize
+	if partSize <= 0 {
+		partSize = DefaultPartSize
+	}
+	if partSize > MaxPartSize {
+		partSize = MaxPartSize
+	}
+	return partSize
+}
+
 func (drive *Drive) CreateFileWithProof(ctx context.Context, fullPath string, size int64, in io.Reader, sha1Code string, proofCode string, overwrite bool) (*Node, error) {
 	fullPath = normalizePath(fullPath)
 	if strings.HasSuffix(strings.ToLower(fullPath), ".livp") {
@@ -623,22 +742,159 @@ func (drive *Drive) CreateFileWithProof(ctx context.Context, fullPath string, si
 	}
 
 	var proofResult ProofResult
+	partSize := drive.partSizeOrDefault()
+	stateKey := uploadStateKey(fullPath, size, sha1Code)
+	doneBase := 0
+	allPartsDone := false
+
+	// Only set if the pre-hash-matched path below has to spool a
+	// non-seekable reader's bytes to disk to re-read them for upload;
+	// cleaned up once CreateFileWithProof returns.
+	var tempFile *os.File
+	defer func() {
+		if tempFile != nil {
+			_ = tempFile.Close()
+			_ = os.Remove(tempFile.Name())
+		}
+	}()
+
+	// Check for a saved upload from a prior, interrupted attempt *before*
+	// doing anything that would create a new file_id/upload_id (the
+	// pre-hash probe below is itself a create_with_proof call with
+	// auto_rename: true, so running it first would silently abandon the
+	// saved state and leave an orphaned, incomplete sibling file behind).
+	if resumed := drive.loadUploadState(stateKey, size); resumed != nil {
+		partSize = resumed.PartSize
+		parts := makePartInfoList(size, partSize)
+		remaining := parts[resumed.PartsDone:]
+
+		if resumed.PartsDone > 0 {
+			skip := int64(resumed.PartsDone) * partSize
+			if seeker, ok := in.(io.Seeker); ok {
+				if _, err := seeker.Seek(skip, io.SeekStart); err != nil {
+					return nil, errors.Wrap(err, "failed to seek to resume offset")
+				}
+			} else if _, err := io.CopyN(ioutil.Discard, in, skip); err != nil {
+				return nil, errors.Wrap(err, "failed to skip already-uploaded bytes on resume")
+			}
+		}
 
-	proof := &FileProof{
-		DriveID:         drive.driveId,
-		PartInfoList:    makePartInfoList(size),
-		ParentFileID:    node.NodeId,
-		Name:            name,
-		Type:            "file",
-		CheckNameMode:   "auto_rename",
-		Size:            size,
-		ContentHash:     sha1Code,
-		ContentHashName: "sha1",
-		ProofCode:       proofCode,
-		ProofVersion:    "v1",
+		if len(remaining) > 0 {
+			if err := drive.refreshUploadUrls(ctx, resumed.FileId, resumed.UploadId, remaining); err != nil {
+				return nil, errors.Wrap(err, "failed to refresh upload urls for resume")
+			}
+		} else {
+			allPartsDone = true
+		}
+
+		doneBase = resumed.PartsDone
+		proofResult = ProofResult{FileId: resumed.FileId, UploadId: resumed.UploadId, PartInfoList: remaining}
+	} else if sha1Code == "" && size > preHashSize {
+		// sha1Code is only known up front when the caller already computed
+		// it (e.g. from a seekable *os.File via CalcSha1). Otherwise probe
+		// with a pre-hash of just the first preHashSize bytes first, so we
+		// don't pay for a full-file SHA1 on the common case of a large,
+		// non-duplicate upload.
+		originalIn := in
+		prefix, rewound, err := peekPrefix(in, preHashSize)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read pre-hash prefix")
+		}
+		in = rewound
+
+		preHashProof := &FileProof{
+			DriveID:       drive.driveId,
+			ParentFileID:  node.NodeId,
+			Name:          name,
+			Type:          "file",
+			CheckNameMode: "auto_rename",
+			Size:          size,
+			PreHash:       calcPreHash(prefix),
+		}
+
+		var preHashResult ProofResult
+		err = drive.jsonRequest(ctx, "POST", apiCreateFileWithProof, preHashProof, &preHashResult)
+		switch {
+		case err != nil && isPreHashMatched(err):
+			// possible rapid-upload candidate: only now is it worth paying
+			// for the full hash and retrying with a real proof. If the
+			// caller handed us a seekable *os.File we can rewind it and
+			// stream the hash straight off disk, same as CalcSha1/calcProof
+			// do, without holding the file in memory. A non-seekable
+			// reader has already had its prefix consumed and can't be
+			// rewound, so spool it to a temp file as we hash it instead of
+			// buffering the whole thing in RAM.
+			accessToken, _ := drive.getToken()
+			if f, ok := originalIn.(*os.File); ok {
+				if _, err := f.Seek(0, io.SeekStart); err != nil {
+					return nil, errors.Wrap(err, "failed to rewind file for full sha1")
+				}
+				h := sha1.New()
+				if _, err := io.Copy(h, f); err != nil {
+					return nil, errors.Wrap(err, "failed to calculate sha1")
+				}
+				sha1Code = fmt.Sprintf("%X", h.Sum(nil))
+
+				_, proofCode, err = calcProof(accessToken, size, f)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to calculate proof")
+				}
+				in = f
+			} else {
+				tmp, err := ioutil.TempFile("", "aliyundrive-upload-*")
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to create temp file for full sha1")
+				}
+				tempFile = tmp
+
+				h := sha1.New()
+				if _, err := io.Copy(io.MultiWriter(tmp, h), in); err != nil {
+					return nil, errors.Wrap(err, "failed to calculate sha1")
+				}
+				sha1Code = fmt.Sprintf("%X", h.Sum(nil))
+
+				_, proofCode, err = calcProof(accessToken, size, tmp)
+				if err != nil {
+					return nil, errors.Wrap(err, "failed to calculate proof")
+				}
+				in = tmp
+			}
+		case err != nil:
+			return nil, errors.Wrap(err, "failed to post pre-hash probe")
+		default:
+			// no match possible: skip straight to the upload using the
+			// file_id/upload_id the probe already handed back, but still
+			// persist resumable state immediately so a crash before the
+			// first part finishes doesn't lose the file_id/upload_id (this
+			// is the common path, not the exceptional one).
+			proofResult = preHashResult
+			drive.saveUploadState(stateKey, &uploadState{
+				DriveId:  drive.driveId,
+				FileId:   proofResult.FileId,
+				UploadId: proofResult.UploadId,
+				PartSize: partSize,
+				Sha1:     sha1Code,
+				Size:     size,
+				DstPath:  fullPath,
+			})
+		}
 	}
 
-	{
+	if proofResult.FileId == "" {
+		proof := &FileProof{
+			DriveID:         drive.driveId,
+			PartInfoList:    makePartInfoList(size, partSize),
+			ParentFileID:    node.NodeId,
+			Name:            name,
+			Type:            "file",
+			CheckNameMode:   "auto_rename",
+			Size:            size,
+			ContentHash:     sha1Code,
+			ContentHashName: "sha1",
+			ProofCode:       proofCode,
+			ProofVersion:    "v1",
+		}
+
 		err = drive.jsonRequest(ctx, "POST", "https://api.aliyundrive.com/v2/file/create_with_proof", proof, &proofResult)
 		if err != nil {
 			return nil, errors.Wrap(err, `failed to post create file request`)
@@ -646,25 +902,46 @@ func (drive *Drive) CreateFileWithProof(ctx context.Context, fullPath string, si
 
 		if proofResult.RapidUpload {
 			// rapid upload
+			drive.deleteUploadState(stateKey)
 			return drive.Get(ctx, fullPath, FileKind)
 		}
 
-		if len(proofResult.PartInfoList) < 1 {
-			return nil, errors.New(`failed to extract uploadUrl`)
+		drive.saveUploadState(stateKey, &uploadState{
+			DriveId:  drive.driveId,
+			FileId:   proofResult.FileId,
+			UploadId: proofResult.UploadId,
+			PartSize: partSize,
+			Sha1:     sha1Code,
+			Size:     size,
+			DstPath:  fullPath,
+		})
+	}
+
+	if len(proofResult.PartInfoList) < 1 && !allPartsDone {
+		return nil, errors.New(`failed to extract uploadUrl`)
+	}
+
+	if len(proofResult.PartInfoList) > 0 {
+		job := uploadJob{
+			fileId:   proofResult.FileId,
+			uploadId: proofResult.UploadId,
+			partSize: partSize,
+			total:    size,
+			stateKey: stateKey,
+			doneBase: doneBase,
+			baseState: uploadState{
+				DriveId:  drive.driveId,
+				FileId:   proofResult.FileId,
+				UploadId: proofResult.UploadId,
+				PartSize: partSize,
+				Sha1:     sha1Code,
+				Size:     size,
+				DstPath:  fullPath,
+			},
 		}
-	}
-
-	for _, part := range proofResult.PartInfoList {
-		partReader := io.LimitReader(in, MaxPartSize)
-		req, err := http.NewRequestWithContext(ctx, "PUT", part.UploadUrl, partReader)
-		if err != nil {
-			return nil, errors.Wrap(err, "failed to create upload request")
-		}
-		resp, err := drive.httpClient.Do(req)
-		if err != nil {
+		if err := drive.uploadParts(ctx, in, proofResult.PartInfoList, job); err != nil {
 			return nil, errors.Wrap(err, "failed to upload file")
 		}
-		resp.Body.Close()
 	}
 
 	var createdNode Node
@@ -680,6 +957,7 @@ func (drive *Drive) CreateFileWithProof(ctx context.Context, fullPath string, si
 			return nil, errors.Wrap(err, `failed to post upload complete request`)
 		}
 	}
+	drive.deleteUploadState(stateKey)
 	return &createdNode, nil
 }
 
@@ -0,0 +1,119 @@
+package drive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// APIError represents Aliyun Drive's JSON error envelope, e.g.
+// {"code":"AccessTokenInvalid","message":"..."}. Callers can type-assert
+// on it to react to specific codes (QuotaExceeded, ShareLinkTokenInvalid,
+// AccessTokenInvalid, TooManyRequests, ...) instead of string-matching
+// response bodies.
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// isPreHashMatched reports whether err is the typed APIError Aliyun
+// returns for a create_with_proof pre_hash call whose prefix matches an
+// existing file, i.e. a possible rapid-upload candidate.
+func isPreHashMatched(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.Code == "PreHashMatched"
+}
+
+const (
+	pacerMinSleep   = 200 * time.Millisecond
+	pacerMaxSleep   = 5 * time.Second
+	pacerFactor     = 2.0
+	pacerMaxRetries = 8
+)
+
+// shouldRetry decides whether a failed Aliyun API call is worth retrying.
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return true
+		}
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+
+	if resp == nil {
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return true
+	default:
+		return false
+	}
+}
+
+// retryDelay honors a Retry-After response header when present, otherwise
+// returns a jittered exponential backoff for the given attempt.
+func retryDelay(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		if ra := resp.Header.Get("Retry-After"); ra != "" {
+			if secs, err := strconv.Atoi(ra); err == nil {
+				return time.Duration(secs) * time.Second
+			}
+		}
+	}
+
+	sleep := pacerMinSleep
+	for i := 0; i < attempt; i++ {
+		sleep = time.Duration(float64(sleep) * pacerFactor)
+		if sleep >= pacerMaxSleep {
+			sleep = pacerMaxSleep
+			break
+		}
+	}
+	return sleep/2 + time.Duration(rand.Int63n(int64(sleep)/2+1))
+}
+
+// pace retries fn until it succeeds, shouldRetry says to give up, or
+// pacerMaxRetries attempts have been made. The caller still owns closing
+// resp.Body.
+func pace(ctx context.Context, fn func() (*http.Response, error)) (*http.Response, error) {
+	var resp *http.Response
+	var err error
+
+	for attempt := 0; attempt < pacerMaxRetries; attempt++ {
+		resp, err = fn()
+		if !shouldRetry(ctx, resp, err) || attempt == pacerMaxRetries-1 {
+			return resp, err
+		}
+
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(retryDelay(resp, attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return resp, err
+}
@@ -0,0 +1,81 @@
+package drive
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	cancelledCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cases := []struct {
+		name string
+		ctx  context.Context
+		resp *http.Response
+		err  error
+		want bool
+	}{
+		{"context done always gives up", cancelledCtx, nil, io.EOF, false},
+		{"unexpected EOF is retried", context.Background(), nil, io.ErrUnexpectedEOF, true},
+		{"EOF is retried", context.Background(), nil, io.EOF, true},
+		{"other errors are not retried", context.Background(), nil, errors.New("boom"), false},
+		{"nil response and nil error is not retried", context.Background(), nil, nil, false},
+		{"429 is retried", context.Background(), &http.Response{StatusCode: http.StatusTooManyRequests}, nil, true},
+		{"500 is retried", context.Background(), &http.Response{StatusCode: http.StatusInternalServerError}, nil, true},
+		{"502 is retried", context.Background(), &http.Response{StatusCode: http.StatusBadGateway}, nil, true},
+		{"503 is retried", context.Background(), &http.Response{StatusCode: http.StatusServiceUnavailable}, nil, true},
+		{"504 is retried", context.Background(), &http.Response{StatusCode: http.StatusGatewayTimeout}, nil, true},
+		{"408 is retried", context.Background(), &http.Response{StatusCode: http.StatusRequestTimeout}, nil, true},
+		{"404 is not retried", context.Background(), &http.Response{StatusCode: http.StatusNotFound}, nil, false},
+		{"200 is not retried", context.Background(), &http.Response{StatusCode: http.StatusOK}, nil, false},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := shouldRetry(c.ctx, c.resp, c.err)
+			if got != c.want {
+				t.Errorf("shouldRetry(%v, %v) = %v, want %v", c.resp, c.err, got, c.want)
+			}
+		})
+	}
+}
+
+func TestRetryDelayRetryAfterHeader(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"3"}}}
+	got := retryDelay(resp, 0)
+	if got != 3*time.Second {
+		t.Errorf("retryDelay with Retry-After=3 = %v, want 3s", got)
+	}
+}
+
+func TestRetryDelayExponentialBackoff(t *testing.T) {
+	cases := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{0, pacerMinSleep},
+		{1, pacerMinSleep * 2},
+		{2, pacerMinSleep * 4},
+		{10, pacerMaxSleep},
+	}
+
+	for _, c := range cases {
+		got := retryDelay(nil, c.attempt)
+		if got < 0 || got > c.max {
+			t.Errorf("retryDelay(nil, %d) = %v, want in [0, %v]", c.attempt, got, c.max)
+		}
+	}
+}
+
+func TestRetryDelayIgnoresUnparseableRetryAfter(t *testing.T) {
+	resp := &http.Response{Header: http.Header{"Retry-After": []string{"not-a-number"}}}
+	got := retryDelay(resp, 0)
+	if got < 0 || got > pacerMinSleep {
+		t.Errorf("retryDelay with malformed Retry-After = %v, want in [0, %v]", got, pacerMinSleep)
+	}
+}
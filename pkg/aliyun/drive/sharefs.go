@@ -0,0 +1,504 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiShareGetToken    = "https://api.aliyundrive.com/v2/share_link/get_share_token"
+	apiShareListByShare = "https://api.aliyundrive.com/adrive/v2/file/list_by_share"
+	apiShareGetByShare  = "https://api.aliyundrive.com/v2/file/get_by_share"
+	apiShareDownloadUrl = "https://api.aliyundrive.com/v2/file/get_share_link_download_url"
+	apiShareSaveToDrive = "https://api.aliyundrive.com/adrive/v2/file/copy"
+
+	// shareTokenRefreshMargin refreshes the share token well before
+	// Aliyun's ~2h expiry so in-flight requests don't race the cutover.
+	shareTokenRefreshMargin = 10 * time.Minute
+)
+
+// ErrReadOnly is returned by every mutating ShareFs method; a share link
+// is consumed read-only unless the caller explicitly SaveToDrive's a node
+// into their own drive.
+var ErrReadOnly = errors.New("share link is read-only")
+
+// ShareConfig configures a ShareFs against a single public share link.
+type ShareConfig struct {
+	ShareId       string
+	SharePassword string
+
+	// RefreshToken is only required for SaveToDrive, which needs a
+	// regular user access token alongside the share token.
+	RefreshToken string
+	HttpClient   *http.Client
+
+	// DownloadConcurrency and DownloadChunkSize tune Open's ranged-GET
+	// pool the same way they do on Config; see Config for defaults.
+	DownloadConcurrency int
+	DownloadChunkSize   int64
+}
+
+// ShareFs is a read-only Fs backed by an Aliyun Drive share link. It
+// authenticates with an x-share-token instead of a bearer access token.
+// The token is refreshed on demand: a request made within
+// shareTokenRefreshMargin of its expiry fetches a new one first. There is
+// no background timer, so an idle ShareFs does not refresh it.
+type ShareFs struct {
+	config     ShareConfig
+	httpClient *http.Client
+	shareId    string
+
+	mutex      sync.Mutex
+	shareToken string
+	expireAt   int64
+
+	owner *Drive // lazily created by SaveToDrive
+}
+
+func (fs *ShareFs) String() string {
+	return fmt.Sprintf("AliyunDriveShare{shareId: %s}", fs.shareId)
+}
+
+func NewShareFs(ctx context.Context, config ShareConfig) (*ShareFs, error) {
+	fs := &ShareFs{
+		config:     config,
+		httpClient: config.HttpClient,
+		shareId:    config.ShareId,
+	}
+	if fs.httpClient == nil {
+		fs.httpClient = http.DefaultClient
+	}
+
+	if err := fs.refreshShareToken(ctx); err != nil {
+		return nil, errors.Wrap(err, "failed to get share token")
+	}
+
+	return fs, nil
+}
+
+type shareTokenResponse struct {
+	ShareToken string `json:"share_token"`
+	ExpiresIn  int64  `json:"expires_in"`
+}
+
+func (fs *ShareFs) refreshShareToken(ctx context.Context) error {
+	data := map[string]string{
+		"share_id":  fs.shareId,
+		"share_pwd": fs.config.SharePassword,
+	}
+	b, err := json.Marshal(&data)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiShareGetToken, bytes.NewBuffer(b))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("content-type", "application/json;charset=UTF-8")
+	req.Header.Set("Referer", "https://www.aliyundrive.com/")
+	req.Header.Set("User-Agent", fakeUA)
+
+	res, err := fs.httpClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if res.StatusCode >= 400 {
+		return errors.Errorf(`failed to request "%s", got "%d"`, apiShareGetToken, res.StatusCode)
+	}
+
+	var tokenResp shareTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return errors.Wrapf(err, `failed to parse response "%s"`, string(body))
+	}
+
+	expiresIn := tokenResp.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = int64(shareTokenRefreshMargin.Seconds())
+	}
+
+	fs.mutex.Lock()
+	fs.shareToken = tokenResp.ShareToken
+	fs.expireAt = time.Now().Unix() + expiresIn - int64(shareTokenRefreshMargin.Seconds())
+	fs.mutex.Unlock()
+
+	return nil
+}
+
+func (fs *ShareFs) currentShareToken(ctx context.Context) (string, error) {
+	fs.mutex.Lock()
+	expired := time.Now().Unix() >= fs.expireAt
+	token := fs.shareToken
+	fs.mutex.Unlock()
+
+	if !expired {
+		return token, nil
+	}
+
+	if err := fs.refreshShareToken(ctx); err != nil {
+		return "", err
+	}
+
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+	return fs.shareToken, nil
+}
+
+func (fs *ShareFs) jsonRequest(ctx context.Context, url string, request interface{}, response interface{}) error {
+	token, err := fs.currentShareToken(ctx)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var bodyBytes []byte
+	if request != nil {
+		b, err := json.Marshal(request)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		bodyBytes = b
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(bodyBytes))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("content-type", "application/json;charset=UTF-8")
+	req.Header.Set("x-share-token", token)
+	req.Header.Set("Referer", "https://www.aliyundrive.com/")
+	req.Header.Set("User-Agent", fakeUA)
+
+	res, err := fs.httpClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return errors.Wrapf(os.ErrNotExist, `failed to request "%s", got "%d"`, url, res.StatusCode)
+	}
+	if res.StatusCode >= 400 {
+		return errors.Errorf(`failed to request "%s", got "%d"`, url, res.StatusCode)
+	}
+
+	if response != nil {
+		b, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err := json.Unmarshal(b, response); err != nil {
+			return errors.Wrapf(err, `failed to parse response "%s"`, string(b))
+		}
+	}
+
+	return nil
+}
+
+func (fs *ShareFs) listNodes(ctx context.Context, node *Node) ([]Node, error) {
+	data := map[string]interface{}{
+		"share_id":       fs.shareId,
+		"parent_file_id": node.NodeId,
+		"limit":          200,
+		"marker":         "",
+	}
+	var nodes []Node
+	var lNodes *ListNodes
+	for {
+		if lNodes != nil && lNodes.NextMarker == "" {
+			break
+		}
+
+		err := fs.jsonRequest(ctx, apiShareListByShare, &data, &lNodes)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		nodes = append(nodes, lNodes.Items...)
+		data["marker"] = lNodes.NextMarker
+	}
+
+	return nodes, nil
+}
+
+func (fs *ShareFs) findNameNode(ctx context.Context, node *Node, name string, kind string) (*Node, error) {
+	nodes, err := fs.listNodes(ctx, node)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, d := range nodes {
+		if d.Name == name && (kind == AnyKind || d.Type == kind) {
+			return &d, nil
+		}
+	}
+	return nil, errors.Wrapf(os.ErrNotExist, `can't find "%s", kind: "%s" under "%s"`, name, kind, node)
+}
+
+// getByShare fetches full node details by file id, which listNodes alone
+// may not return.
+func (fs *ShareFs) getByShare(ctx context.Context, fileId string) (*Node, error) {
+	data := map[string]string{
+		"share_id": fs.shareId,
+		"file_id":  fileId,
+	}
+	var node Node
+	if err := fs.jsonRequest(ctx, apiShareGetByShare, &data, &node); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &node, nil
+}
+
+func (fs *ShareFs) Get(ctx context.Context, fullPath string, kind string) (*Node, error) {
+	fullPath = normalizePath(fullPath)
+	if fullPath == "/" || fullPath == "" {
+		return &Node{NodeId: "root", Type: FolderKind, Name: "root"}, nil
+	}
+
+	parent, name := path.Split(fullPath)
+	parentNode, err := fs.Get(ctx, parent, FolderKind)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to request "%s"`, fullPath)
+	}
+
+	node, err := fs.findNameNode(ctx, parentNode, name, kind)
+	if err != nil {
+		return nil, err
+	}
+	return fs.getByShare(ctx, node.NodeId)
+}
+
+func (fs *ShareFs) List(ctx context.Context, fullPath string) ([]Node, error) {
+	fullPath = normalizePath(fullPath)
+	node, err := fs.Get(ctx, fullPath, FolderKind)
+	if err != nil {
+		return nil, findNodeError(err, fullPath)
+	}
+
+	nodes, err := fs.listNodes(ctx, node)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to list nodes of "%s"`, node)
+	}
+	return nodes, nil
+}
+
+func (fs *ShareFs) Open(ctx context.Context, node *Node, headers map[string]string) (io.ReadSeekCloser, error) {
+	return newRangedReader(ctx, fs.shareDownloadUrl, fs.rangedGet, node, node.Size, fs.config.DownloadConcurrency, fs.config.DownloadChunkSize, headers), nil
+}
+
+// shareDownloadUrl resolves node's signed share download url, for use as
+// a rangedReader's urlOpener.
+func (fs *ShareFs) shareDownloadUrl(ctx context.Context, node *Node) (string, error) {
+	data := map[string]string{
+		"share_id": fs.shareId,
+		"file_id":  node.NodeId,
+	}
+	var detail struct {
+		Url string `json:"url"`
+	}
+	if err := fs.jsonRequest(ctx, apiShareDownloadUrl, &data, &detail); err != nil {
+		return "", errors.Wrapf(err, `failed to get download url of "%s"`, node)
+	}
+	return detail.Url, nil
+}
+
+// rangedGet issues a single ranged GET against an already-resolved share
+// download url, for use as a rangedReader's rangeGetter.
+func (fs *ShareFs) rangedGet(ctx context.Context, url string, offset, length int64, headers map[string]string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Referer", "https://www.aliyundrive.com/")
+	req.Header.Set("User-Agent", fakeUA)
+	if length > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	} else {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := fs.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, `failed to download "%s"`, url)
+	}
+	return res, nil
+}
+
+// OpenRange downloads node's content in [offset, offset+length) (length
+// <= 0 means "to EOF") via a single ranged GET against its share download
+// url.
+func (fs *ShareFs) OpenRange(ctx context.Context, node *Node, offset, length int64, headers map[string]string) (io.ReadCloser, error) {
+	url, err := fs.shareDownloadUrl(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := fs.rangedGet(ctx, url, offset, length, headers)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 400 {
+		_ = res.Body.Close()
+		return nil, errors.Errorf(`failed to download "%s", got "%d"`, node, res.StatusCode)
+	}
+
+	return res.Body, nil
+}
+
+// ownerDrive lazily logs into the caller's own drive with
+// config.RefreshToken, for SaveToDrive.
+func (fs *ShareFs) ownerDrive(ctx context.Context) (*Drive, error) {
+	fs.mutex.Lock()
+	defer fs.mutex.Unlock()
+
+	if fs.owner != nil {
+		return fs.owner, nil
+	}
+	if fs.config.RefreshToken == "" {
+		return nil, errors.New("ShareConfig.RefreshToken is required for SaveToDrive")
+	}
+
+	owner, err := NewFs(ctx, &Config{
+		RefreshToken: fs.config.RefreshToken,
+		HttpClient:   fs.httpClient,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to authenticate for save-to-drive")
+	}
+
+	fs.owner = owner.(*Drive)
+	return fs.owner, nil
+}
+
+// SaveToDrive copies node from the share into dstParent in the caller's
+// own drive.
+func (fs *ShareFs) SaveToDrive(ctx context.Context, node *Node, dstParent *Node) (*Node, error) {
+	owner, err := fs.ownerDrive(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := fs.currentShareToken(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	accessToken, expireAt := owner.getToken()
+	if expireAt < time.Now().Unix() {
+		if err := owner.refreshToken(ctx); err != nil {
+			return nil, errors.WithStack(err)
+		}
+		accessToken, _ = owner.getToken()
+	}
+
+	body := map[string]string{
+		"file_id":           node.NodeId,
+		"share_id":          fs.shareId,
+		"to_drive_id":       owner.driveId,
+		"to_parent_file_id": dstParent.NodeId,
+		"auto_rename":       "true",
+	}
+	b, err := json.Marshal(&body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", apiShareSaveToDrive, bytes.NewBuffer(b))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	req.Header.Set("content-type", "application/json;charset=UTF-8")
+	req.Header.Set("authorization", "Bearer "+accessToken)
+	req.Header.Set("x-share-token", token)
+	req.Header.Set("Referer", "https://www.aliyundrive.com/")
+	req.Header.Set("User-Agent", fakeUA)
+
+	res, err := fs.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	b, err = ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if res.StatusCode >= 400 {
+		return nil, errors.Errorf(`failed to request "%s", got "%d": %s`, apiShareSaveToDrive, res.StatusCode, string(b))
+	}
+
+	var createdNode Node
+	if err := json.Unmarshal(b, &createdNode); err != nil {
+		return nil, errors.Wrapf(err, `failed to parse response "%s"`, string(b))
+	}
+	return &createdNode, nil
+}
+
+// the rest of Fs is read-only on a share link
+
+func (fs *ShareFs) CreateFolder(ctx context.Context, fullPath string) (*Node, error) {
+	return nil, ErrReadOnly
+}
+
+func (fs *ShareFs) Rename(ctx context.Context, node *Node, newName string) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) Move(ctx context.Context, node *Node, dstParent *Node, dstName string) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) Remove(ctx context.Context, node *Node) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) CreateFile(ctx context.Context, fullPath string, size int64, in io.Reader, overwrite bool) (*Node, error) {
+	return nil, ErrReadOnly
+}
+
+func (fs *ShareFs) CalcProof(fileSize int64, in *os.File) (*os.File, string, error) {
+	return in, "", ErrReadOnly
+}
+
+func (fs *ShareFs) CreateFileWithProof(ctx context.Context, fullPath string, size int64, in io.Reader, sha1Code string, proofCode string, overwrite bool) (*Node, error) {
+	return nil, ErrReadOnly
+}
+
+func (fs *ShareFs) Copy(ctx context.Context, node *Node, dstParent *Node, dstName string) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) Batch(ctx context.Context, requests []BatchRequest) (map[string]BatchResult, error) {
+	return nil, ErrReadOnly
+}
+
+func (fs *ShareFs) MoveBatch(ctx context.Context, nodes []*Node, dstParent *Node) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) CopyBatch(ctx context.Context, nodes []*Node, dstParent *Node) error {
+	return ErrReadOnly
+}
+
+func (fs *ShareFs) RemoveBatch(ctx context.Context, nodes []*Node) error {
+	return ErrReadOnly
+}
+
+var _ Fs = (*ShareFs)(nil)
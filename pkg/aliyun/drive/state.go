@@ -0,0 +1,125 @@
+package drive
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// UploadStateStore persists in-flight upload progress so CreateFile can
+// resume a multipart upload after a crash or a dropped connection instead
+// of starting over from byte zero.
+type UploadStateStore interface {
+	Save(key string, state []byte) error
+	Load(key string) ([]byte, error)
+	Delete(key string) error
+}
+
+// uploadState is what gets persisted for a single in-flight upload.
+type uploadState struct {
+	DriveId   string `json:"drive_id"`
+	FileId    string `json:"file_id"`
+	UploadId  string `json:"upload_id"`
+	PartSize  int64  `json:"part_size"`
+	PartsDone int    `json:"parts_done"`
+	Sha1      string `json:"sha1"`
+	Size      int64  `json:"size"`
+	DstPath   string `json:"dst_path"`
+}
+
+// uploadStateKey derives a stable key for an upload from the bits that
+// identify "the same upload" across retries: destination path, size and
+// content hash. sha1Code may be empty when the pre-hash path skipped the
+// full hash; resume is then keyed on path+size alone.
+func uploadStateKey(dstPath string, size int64, sha1Code string) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s|%d|%s", dstPath, size, sha1Code)
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// JSONFileUploadStateStore is the default UploadStateStore: one JSON file
+// per upload key under Dir.
+type JSONFileUploadStateStore struct {
+	Dir string
+}
+
+func NewJSONFileUploadStateStore(dir string) *JSONFileUploadStateStore {
+	return &JSONFileUploadStateStore{Dir: dir}
+}
+
+func (s *JSONFileUploadStateStore) path(key string) string {
+	return filepath.Join(s.Dir, key+".json")
+}
+
+func (s *JSONFileUploadStateStore) Save(key string, state []byte) error {
+	if err := os.MkdirAll(s.Dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(ioutil.WriteFile(s.path(key), state, 0644))
+}
+
+func (s *JSONFileUploadStateStore) Load(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(s.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.WithStack(err)
+	}
+	return b, nil
+}
+
+func (s *JSONFileUploadStateStore) Delete(key string) error {
+	err := os.Remove(s.path(key))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (drive *Drive) loadUploadState(key string, size int64) *uploadState {
+	store := drive.config.UploadStateStore
+	if store == nil || key == "" {
+		return nil
+	}
+
+	b, err := store.Load(key)
+	if err != nil || b == nil {
+		return nil
+	}
+
+	var st uploadState
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil
+	}
+	if st.DriveId != drive.driveId || st.Size != size {
+		return nil
+	}
+	return &st
+}
+
+func (drive *Drive) saveUploadState(key string, st *uploadState) {
+	store := drive.config.UploadStateStore
+	if store == nil || key == "" {
+		return
+	}
+
+	b, err := json.Marshal(st)
+	if err != nil {
+		return
+	}
+	_ = store.Save(key, b)
+}
+
+func (drive *Drive) deleteUploadState(key string) {
+	store := drive.config.UploadStateStore
+	if store == nil || key == "" {
+		return
+	}
+	_ = store.Delete(key)
+}
@@ -0,0 +1,209 @@
+package drive
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	apiGetUploadUrl = "https://api.aliyundrive.com/v2/file/get_upload_url"
+
+	maxPartRetries        = 5
+	maxUploadUrlRefreshes = 3
+	partRetryBaseBackoff  = 200 * time.Millisecond
+)
+
+// uploadJob carries the bits of CreateFileWithProof's state that the part
+// uploader needs but that aren't part of a single PartInfo.
+type uploadJob struct {
+	fileId   string
+	uploadId string
+	partSize int64
+	total    int64
+
+	// stateKey, doneBase and baseState let uploadParts persist resumable
+	// progress as parts complete. doneBase is how many parts (from part
+	// 1) were already done before this call, e.g. from a prior resume.
+	// Nothing is persisted when no UploadStateStore is configured, since
+	// saveUploadState is then a no-op.
+	stateKey  string
+	doneBase  int
+	baseState uploadState
+}
+
+// uploadParts streams in, split into len(parts) chunks of job.partSize
+// bytes, into the signed per-part URLs create_with_proof returned. Parts
+// are read from in sequentially (it may not be seekable) but uploaded by a
+// bounded worker pool, so one slow or failing part doesn't stall the rest
+// of a large file.
+func (drive *Drive) uploadParts(ctx context.Context, in io.Reader, parts []*PartInfo, job uploadJob) error {
+	concurrency := drive.config.UploadConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultUploadConcurrency
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var uploaded int64
+	var firstErr error
+	completed := make(map[int]bool, len(parts))
+	nextToPersist := job.doneBase + 1
+	persistedThrough := job.doneBase
+
+	for _, part := range parts {
+		mu.Lock()
+		stop := firstErr != nil
+		mu.Unlock()
+		if stop {
+			break
+		}
+
+		buf := make([]byte, job.partSize)
+		n, err := io.ReadFull(in, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			wg.Wait()
+			return errors.Wrapf(err, "failed to read part %d", part.PartNumber)
+		}
+		buf = buf[:n]
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(part *PartInfo, data []byte) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := drive.uploadPartWithRetry(ctx, job.fileId, job.uploadId, part, data)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+
+			uploaded += int64(len(data))
+			if drive.config.ProgressFunc != nil {
+				drive.config.ProgressFunc(uploaded, job.total)
+			}
+
+			if job.stateKey == "" {
+				return
+			}
+			completed[part.PartNumber] = true
+			for completed[nextToPersist] {
+				nextToPersist++
+			}
+			if nextToPersist-1 > persistedThrough {
+				persistedThrough = nextToPersist - 1
+				st := job.baseState
+				st.PartsDone = persistedThrough
+				drive.saveUploadState(job.stateKey, &st)
+			}
+		}(part, buf)
+	}
+
+	wg.Wait()
+	return firstErr
+}
+
+// uploadPartWithRetry PUTs a single part, retrying on transient failures
+// with exponential backoff and refreshing the signed upload URL if it has
+// expired mid-upload.
+func (drive *Drive) uploadPartWithRetry(ctx context.Context, fileId, uploadId string, part *PartInfo, data []byte) error {
+	backoff := partRetryBaseBackoff
+	refreshes := 0
+	var lastErr error
+
+	for attempt := 0; attempt < maxPartRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			backoff *= 2
+		}
+
+		status, err := drive.putPart(ctx, part.UploadUrl, data)
+		if err == nil && status < 300 {
+			return nil
+		}
+
+		if status == http.StatusForbidden && refreshes < maxUploadUrlRefreshes {
+			refreshes++
+			if refreshErr := drive.refreshUploadUrl(ctx, fileId, uploadId, part); refreshErr != nil {
+				lastErr = refreshErr
+				continue
+			}
+			continue
+		}
+
+		lastErr = err
+		if lastErr == nil {
+			lastErr = errors.Errorf("part %d upload failed with status %d", part.PartNumber, status)
+		}
+	}
+
+	return errors.Wrapf(lastErr, "failed to upload part %d after %d attempts", part.PartNumber, maxPartRetries)
+}
+
+func (drive *Drive) putPart(ctx context.Context, url string, data []byte) (int, error) {
+	resp, err := pace(ctx, func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, "PUT", url, bytes.NewReader(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create upload request")
+		}
+		return drive.httpClient.Do(req)
+	})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// refreshUploadUrl re-requests a signed PUT url for a single part whose
+// previous one has expired.
+func (drive *Drive) refreshUploadUrl(ctx context.Context, fileId, uploadId string, part *PartInfo) error {
+	return drive.refreshUploadUrls(ctx, fileId, uploadId, []*PartInfo{part})
+}
+
+// refreshUploadUrls re-requests signed PUT urls for parts whose previous
+// ones have expired, updating each PartInfo in place.
+func (drive *Drive) refreshUploadUrls(ctx context.Context, fileId, uploadId string, parts []*PartInfo) error {
+	body := map[string]interface{}{
+		"drive_id":       drive.driveId,
+		"file_id":        fileId,
+		"upload_id":      uploadId,
+		"part_info_list": parts,
+	}
+
+	var result ProofResult
+	err := drive.jsonRequest(ctx, "POST", apiGetUploadUrl, &body, &result)
+	if err != nil {
+		return errors.Wrap(err, "failed to refresh upload url")
+	}
+	if len(result.PartInfoList) < 1 {
+		return errors.New("get_upload_url returned no parts")
+	}
+
+	fresh := make(map[int]string, len(result.PartInfoList))
+	for _, p := range result.PartInfoList {
+		fresh[p.PartNumber] = p.UploadUrl
+	}
+	for _, p := range parts {
+		if url, ok := fresh[p.PartNumber]; ok {
+			p.UploadUrl = url
+		}
+	}
+	return nil
+}